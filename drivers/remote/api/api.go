@@ -0,0 +1,178 @@
+// Package api defines the structured request/response types exchanged
+// between libnetwork and a remote network driver plugin, replacing the
+// untyped map[string]interface{} payloads the protocol used to speak so
+// that schema drift is caught at compile time.
+package api
+
+// DefaultVersion is the remote driver protocol version used when a plugin
+// does not report one in its GetCapabilities response.
+const DefaultVersion = 1
+
+// MinVersionConfig is the first protocol version at which a plugin is
+// expected to implement the Config RPC. Plugins negotiating an older
+// version (or no version at all, via DefaultVersion) predate Config and
+// must not be sent it, or registration would fail against every
+// pre-existing remote plugin.
+const MinVersionConfig = 2
+
+// Response is the common response format for all relevant plugin
+// operations. It gets embedded in other response structs so they all
+// carry an Err field the caller can check.
+type Response struct {
+	Err string
+}
+
+// GetError returns the error from the response, if any.
+func (r *Response) GetError() string {
+	return r.Err
+}
+
+// GetCapabilityResponse is the response of the GetCapabilities RPC.
+type GetCapabilityResponse struct {
+	Response
+	Scope                 string
+	RequiresMACAddress    bool
+	RequiresRequestReplay bool
+	// Version is the remote driver protocol version this plugin speaks.
+	// Libnetwork negotiates it during Plugin.Activate/GetCapabilities so
+	// later fields can be added to the protocol without breaking older
+	// plugins that don't know about them.
+	Version int
+}
+
+// IPAMData represents the per-address-family IPAM data passed to a remote
+// driver's CreateNetwork, as resolved by the IPAM driver before the network
+// driver is invoked.
+type IPAMData struct {
+	AddressSpace string
+	Pool         string
+	Gateway      string
+	AuxAddresses map[string]string
+}
+
+// CreateNetworkRequest is sent by the driver.CreateNetwork method.
+type CreateNetworkRequest struct {
+	NetworkID string
+	Options   map[string]interface{}
+	IPv4Data  []IPAMData
+	IPv6Data  []IPAMData
+}
+
+// DeleteNetworkRequest is sent by the driver.DeleteNetwork method.
+type DeleteNetworkRequest struct {
+	NetworkID string
+}
+
+// EndpointInterface represents an interface endpoint.
+type EndpointInterface struct {
+	Address     string
+	AddressIPv6 string
+	MacAddress  string
+}
+
+// StaticRoute is a static route as communicated over the remote driver protocol.
+type StaticRoute struct {
+	Destination string
+	RouteType   int
+	NextHop     string
+}
+
+// CreateEndpointRequest is sent by the driver.CreateEndpoint method.
+type CreateEndpointRequest struct {
+	NetworkID  string
+	EndpointID string
+	Interface  *EndpointInterface
+	Options    map[string]interface{}
+}
+
+// CreateEndpointResponse is the response to the CreateEndpointRequest.
+type CreateEndpointResponse struct {
+	Response
+	Interface EndpointInterface
+}
+
+// DeleteEndpointRequest is sent by the driver.DeleteEndpoint method.
+type DeleteEndpointRequest struct {
+	NetworkID  string
+	EndpointID string
+}
+
+// EndpointInfoRequest is sent by the driver.EndpointOperInfo method.
+type EndpointInfoRequest struct {
+	NetworkID  string
+	EndpointID string
+}
+
+// EndpointInfoResponse is the response to the EndpointInfoRequest.
+type EndpointInfoResponse struct {
+	Response
+	Value map[string]interface{}
+}
+
+// JoinRequest is sent by the driver.Join method.
+type JoinRequest struct {
+	NetworkID  string
+	EndpointID string
+	SandboxKey string
+	Options    map[string]interface{}
+}
+
+// InterfaceName is the name midway of an interface as returned in JoinResponse.
+type InterfaceName struct {
+	SrcName   string
+	DstPrefix string
+}
+
+// JoinResponse is the response to the JoinRequest.
+type JoinResponse struct {
+	Response
+	Gateway        string
+	GatewayIPv6    string
+	HostsPath      string
+	ResolvConfPath string
+	InterfaceName  InterfaceName
+	StaticRoutes   []StaticRoute
+}
+
+// LeaveRequest is sent by the driver.Leave method.
+type LeaveRequest struct {
+	NetworkID  string
+	EndpointID string
+}
+
+// ConfigRequest is sent once, at registration time, to push libnetwork's
+// generic driver options down to the plugin.
+type ConfigRequest struct {
+	Options map[string]interface{}
+}
+
+// DiscoveryNotification is sent to the plugin on DiscoverNew/DiscoverDelete,
+// carrying the discovery type and its associated, type-specific data.
+type DiscoveryNotification struct {
+	DiscoveryType int
+	DiscoveryData interface{}
+}
+
+// NodeDiscoveryData is the DiscoveryData carried by a node join/leave
+// notification, identifying the node and whether it is the local node.
+type NodeDiscoveryData struct {
+	Address string
+	Self    bool
+}
+
+// ProgramExternalConnectivityRequest is sent by the
+// driver.ProgramExternalConnectivity method. Options carries the
+// endpoint's exposed ports and port mappings (types.TransportPort and
+// types.PortBinding slices) under their usual generic-option keys.
+type ProgramExternalConnectivityRequest struct {
+	NetworkID  string
+	EndpointID string
+	Options    map[string]interface{}
+}
+
+// RevokeExternalConnectivityRequest is sent by the
+// driver.RevokeExternalConnectivity method.
+type RevokeExternalConnectivityRequest struct {
+	NetworkID  string
+	EndpointID string
+}
@@ -0,0 +1,346 @@
+package remote
+
+import (
+	"fmt"
+	"net"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/pkg/plugins"
+	"github.com/docker/libnetwork/config"
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/drivers/remote/api"
+)
+
+type driver struct {
+	endpoint    *plugins.Client
+	networkType string
+	version     int
+}
+
+type maybeError interface {
+	GetError() string
+}
+
+func newDriver(name string, client *plugins.Client) driverapi.Driver {
+	return &driver{networkType: name, endpoint: client, version: api.DefaultVersion}
+}
+
+// Init makes sure a remote driver is registered when a network driver
+// plugin is activated.
+func Init(dc driverapi.DriverCallback, drvConfig map[string]interface{}) error {
+	plugins.Handle(driverapi.NetworkPluginEndpointType, func(name string, client *plugins.Client) {
+		d := newDriver(name, client)
+		c, err := d.(*driver).getCapabilities()
+		if err != nil {
+			log.Errorf("error getting capability for %s due to %v", name, err)
+			return
+		}
+		if d.(*driver).version >= api.MinVersionConfig {
+			if err := d.(*driver).pushDriverConfig(drvConfig); err != nil {
+				log.Errorf("error pushing config to %s due to %v", name, err)
+				return
+			}
+		}
+		if err = dc.RegisterDriver(name, d, *c); err != nil {
+			log.Errorf("error registering driver for %s due to %v", name, err)
+		}
+	})
+	return nil
+}
+
+// pushDriverConfig pushes the generic options libnetwork was configured
+// with down to the plugin, so that it has its configuration available
+// before the first network operation rather than through a later,
+// out-of-band call.
+func (d *driver) pushDriverConfig(drvConfig map[string]interface{}) error {
+	var opts map[string]interface{}
+	if genericOpts, ok := drvConfig[config.OptionDriverConfig(d.networkType)]; ok {
+		opts, _ = genericOpts.(map[string]interface{})
+	}
+
+	req := &api.ConfigRequest{Options: opts}
+	return d.call("Config", req, &api.Response{})
+}
+
+func (d *driver) call(methodName string, arg interface{}, retVal maybeError) error {
+	method := driverapi.NetworkPluginEndpointType + "." + methodName
+	err := d.endpoint.Call(method, arg, retVal)
+	if err != nil {
+		return err
+	}
+	if e := retVal.GetError(); e != "" {
+		return fmt.Errorf("remote: %s", e)
+	}
+	return nil
+}
+
+func (d *driver) getCapabilities() (*driverapi.Capability, error) {
+	var capResp api.GetCapabilityResponse
+	if err := d.call("GetCapabilities", nil, &capResp); err != nil {
+		return nil, err
+	}
+
+	// Negotiate the protocol version the plugin speaks so later fields
+	// can be added without breaking older plugins that don't report one.
+	if capResp.Version > 0 {
+		d.version = capResp.Version
+	}
+
+	c := &driverapi.Capability{}
+	switch capResp.Scope {
+	case "global":
+		c.DataScope = datastore.GlobalScope
+	case "local":
+		c.DataScope = datastore.LocalScope
+	default:
+		return nil, fmt.Errorf("invalid capability: expecting 'local' or 'global', got %s", capResp.Scope)
+	}
+
+	return c, nil
+}
+
+func (d *driver) Type() string {
+	return d.networkType
+}
+
+func (d *driver) CreateNetwork(id string, options map[string]interface{}, ipV4Data, ipV6Data []driverapi.IPAMData) error {
+	create := &api.CreateNetworkRequest{
+		NetworkID: id,
+		Options:   options,
+		IPv4Data:  toAPIIPAMData(ipV4Data),
+		IPv6Data:  toAPIIPAMData(ipV6Data),
+	}
+	return d.call("CreateNetwork", create, &api.Response{})
+}
+
+func toAPIIPAMData(data []driverapi.IPAMData) []api.IPAMData {
+	result := make([]api.IPAMData, len(data))
+	for i, d := range data {
+		result[i] = api.IPAMData{AddressSpace: d.AddressSpace}
+		if d.Pool != nil {
+			result[i].Pool = d.Pool.String()
+		}
+		if d.Gateway != nil {
+			result[i].Gateway = d.Gateway.String()
+		}
+		if len(d.AuxAddresses) > 0 {
+			result[i].AuxAddresses = make(map[string]string, len(d.AuxAddresses))
+			for k, addr := range d.AuxAddresses {
+				if addr != nil {
+					result[i].AuxAddresses[k] = addr.String()
+				}
+			}
+		}
+	}
+	return result
+}
+
+func (d *driver) DeleteNetwork(nid string) error {
+	delete := &api.DeleteNetworkRequest{
+		NetworkID: nid,
+	}
+	return d.call("DeleteNetwork", delete, &api.Response{})
+}
+
+func (d *driver) CreateEndpoint(nid, eid string, ifInfo driverapi.InterfaceInfo, epOptions map[string]interface{}) error {
+	create := &api.CreateEndpointRequest{
+		NetworkID:  nid,
+		EndpointID: eid,
+		Options:    epOptions,
+	}
+
+	var res api.CreateEndpointResponse
+	if err := d.call("CreateEndpoint", create, &res); err != nil {
+		return err
+	}
+
+	inIface := res.Interface
+	ip, ipnet, err := net.ParseCIDR(inIface.Address)
+	var addr net.IPNet
+	if err == nil {
+		ipnet.IP = ip
+		addr = *ipnet
+	}
+
+	ip6, ip6net, err6 := net.ParseCIDR(inIface.AddressIPv6)
+	var addrv6 net.IPNet
+	if err6 == nil {
+		ip6net.IP = ip6
+		addrv6 = *ip6net
+	}
+
+	var mac net.HardwareAddr
+	if inIface.MacAddress != "" {
+		if mac, err = net.ParseMAC(inIface.MacAddress); err != nil {
+			return fmt.Errorf("remote: invalid mac address %q returned: %v", inIface.MacAddress, err)
+		}
+	}
+
+	if err := ifInfo.AddInterface(mac, addr, addrv6); err != nil {
+		delReq := &api.DeleteEndpointRequest{NetworkID: nid, EndpointID: eid}
+		if e := d.call("DeleteEndpoint", delReq, &api.Response{}); e != nil {
+			log.Warnf("driver failed to roll back endpoint creation after error (%v): %v", err, e)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (d *driver) DeleteEndpoint(nid, eid string) error {
+	delete := &api.DeleteEndpointRequest{
+		NetworkID:  nid,
+		EndpointID: eid,
+	}
+	return d.call("DeleteEndpoint", delete, &api.Response{})
+}
+
+func (d *driver) EndpointOperInfo(nid, eid string) (map[string]interface{}, error) {
+	info := &api.EndpointInfoRequest{
+		NetworkID:  nid,
+		EndpointID: eid,
+	}
+	var res api.EndpointInfoResponse
+	if err := d.call("EndpointOperInfo", info, &res); err != nil {
+		return nil, err
+	}
+	return res.Value, nil
+}
+
+func (d *driver) Join(nid, eid string, sboxKey string, jinfo driverapi.JoinInfo, options map[string]interface{}) error {
+	join := &api.JoinRequest{
+		NetworkID:  nid,
+		EndpointID: eid,
+		SandboxKey: sboxKey,
+		Options:    options,
+	}
+	var res api.JoinResponse
+	if err := d.call("Join", join, &res); err != nil {
+		return err
+	}
+
+	if res.Gateway != "" {
+		if jinfo.SetGateway(net.ParseIP(res.Gateway)) != nil {
+			return fmt.Errorf("remote: invalid gateway %q returned", res.Gateway)
+		}
+	}
+	if res.GatewayIPv6 != "" {
+		if jinfo.SetGatewayIPv6(net.ParseIP(res.GatewayIPv6)) != nil {
+			return fmt.Errorf("remote: invalid IPv6 gateway %q returned", res.GatewayIPv6)
+		}
+	}
+	if res.InterfaceName.SrcName != "" {
+		if err := jinfo.InterfaceName().SetNames(res.InterfaceName.SrcName, res.InterfaceName.DstPrefix); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	for _, route := range res.StaticRoutes {
+		var nextHop net.IP
+		if route.NextHop != "" {
+			nextHop = net.ParseIP(route.NextHop)
+		}
+		_, dest, e := net.ParseCIDR(route.Destination)
+		if e != nil {
+			err = e
+			break
+		}
+		if err = jinfo.AddStaticRoute(dest, route.RouteType, nextHop); err != nil {
+			break
+		}
+	}
+	if err != nil {
+		leave := &api.LeaveRequest{NetworkID: nid, EndpointID: eid}
+		if e := d.call("Leave", leave, &api.Response{}); e != nil {
+			log.Warnf("driver failed to roll back join after error (%v): %v", err, e)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (d *driver) Leave(nid, eid string) error {
+	leave := &api.LeaveRequest{
+		NetworkID:  nid,
+		EndpointID: eid,
+	}
+	return d.call("Leave", leave, &api.Response{})
+}
+
+// ProgramExternalConnectivity asks the plugin to set up port publishing
+// for an endpoint (exposed ports and port mappings, carried in options),
+// so external network plugins can implement it themselves instead of
+// relying on the built-in bridge NAT rules. A failure rolls the endpoint
+// back out of the network by calling Leave.
+func (d *driver) ProgramExternalConnectivity(nid, eid string, options map[string]interface{}) error {
+	data := &api.ProgramExternalConnectivityRequest{
+		NetworkID:  nid,
+		EndpointID: eid,
+		Options:    options,
+	}
+	if err := d.call("ProgramExternalConnectivity", data, &api.Response{}); err != nil {
+		leave := &api.LeaveRequest{NetworkID: nid, EndpointID: eid}
+		if e := d.call("Leave", leave, &api.Response{}); e != nil {
+			log.Warnf("driver failed to roll back endpoint after program external connectivity error (%v): %v", err, e)
+		}
+		return err
+	}
+	return nil
+}
+
+// RevokeExternalConnectivity asks the plugin to tear down the port
+// publishing set up by ProgramExternalConnectivity.
+func (d *driver) RevokeExternalConnectivity(nid, eid string) error {
+	data := &api.RevokeExternalConnectivityRequest{
+		NetworkID:  nid,
+		EndpointID: eid,
+	}
+	return d.call("RevokeExternalConnectivity", data, &api.Response{})
+}
+
+// DiscoverNew forwards a newly discovered node to the plugin so
+// global-scope plugins can build their own cluster view. Only node
+// discovery events are forwarded; any other discovery type, including
+// datastore-scope events, is ignored and nil is returned.
+func (d *driver) DiscoverNew(dType driverapi.DiscoveryType, data interface{}) error {
+	if dType != driverapi.NodeDiscovery {
+		return nil
+	}
+	nodeData, ok := data.(driverapi.NodeDiscoveryData)
+	if !ok || nodeData.Address == "" {
+		return fmt.Errorf("invalid discovery data")
+	}
+	notif := &api.DiscoveryNotification{
+		DiscoveryType: int(dType),
+		DiscoveryData: api.NodeDiscoveryData{
+			Address: nodeData.Address,
+			Self:    nodeData.Self,
+		},
+	}
+	return d.call("DiscoverNew", notif, &api.Response{})
+}
+
+// DiscoverDelete forwards a node leaving to the plugin, mirroring
+// DiscoverNew. Only node discovery events are forwarded; any other
+// discovery type, including datastore-scope events, is ignored and nil is
+// returned.
+func (d *driver) DiscoverDelete(dType driverapi.DiscoveryType, data interface{}) error {
+	if dType != driverapi.NodeDiscovery {
+		return nil
+	}
+	nodeData, ok := data.(driverapi.NodeDiscoveryData)
+	if !ok || nodeData.Address == "" {
+		return fmt.Errorf("invalid discovery data")
+	}
+	notif := &api.DiscoveryNotification{
+		DiscoveryType: int(dType),
+		DiscoveryData: api.NodeDiscoveryData{
+			Address: nodeData.Address,
+			Self:    nodeData.Self,
+		},
+	}
+	return d.call("DiscoverDelete", notif, &api.Response{})
+}
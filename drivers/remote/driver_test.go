@@ -9,26 +9,26 @@ import (
 	"testing"
 
 	"github.com/docker/docker/pkg/plugins"
+	"github.com/docker/libnetwork/config"
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/drivers/remote/api"
 	_ "github.com/docker/libnetwork/testutils"
 	"github.com/docker/libnetwork/types"
 )
 
-func decodeToMap(r *http.Request) (res map[string]interface{}, err error) {
-	err = json.NewDecoder(r.Body).Decode(&res)
-	return
+func decode(t *testing.T, r *http.Request, v interface{}) {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
 }
 
-func handle(t *testing.T, mux *http.ServeMux, method string, h func(map[string]interface{}) interface{}) {
+func handle(t *testing.T, mux *http.ServeMux, method string, h func(msg json.RawMessage) interface{}) {
 	mux.HandleFunc(fmt.Sprintf("/%s.%s", driverapi.NetworkPluginEndpointType, method), func(w http.ResponseWriter, r *http.Request) {
-		ask, err := decodeToMap(r)
-		if err != nil {
-			t.Fatal(err)
-		}
-		answer := h(ask)
-		err = json.NewEncoder(w).Encode(&answer)
-		if err != nil {
+		var raw json.RawMessage
+		decode(t, r, &raw)
+		answer := h(raw)
+		if err := json.NewEncoder(w).Encode(answer); err != nil {
 			t.Fatal(err)
 		}
 	})
@@ -58,6 +58,12 @@ func setupPlugin(t *testing.T, name string, mux *http.ServeMux) func() {
 	}
 }
 
+func decodeRequest(t *testing.T, raw json.RawMessage, v interface{}) {
+	if err := json.Unmarshal(raw, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
 type testEndpoint struct {
 	t              *testing.T
 	src            string
@@ -160,8 +166,8 @@ func TestGetEmptyCapabilities(t *testing.T) {
 	mux := http.NewServeMux()
 	defer setupPlugin(t, plugin, mux)()
 
-	handle(t, mux, "GetCapabilities", func(msg map[string]interface{}) interface{} {
-		return map[string]interface{}{}
+	handle(t, mux, "GetCapabilities", func(msg json.RawMessage) interface{} {
+		return &api.GetCapabilityResponse{}
 	})
 
 	p, err := plugins.Get(plugin, driverapi.NetworkPluginEndpointType)
@@ -186,10 +192,10 @@ func TestGetExtraCapabilities(t *testing.T) {
 	mux := http.NewServeMux()
 	defer setupPlugin(t, plugin, mux)()
 
-	handle(t, mux, "GetCapabilities", func(msg map[string]interface{}) interface{} {
-		return map[string]interface{}{
-			"Scope": "local",
-			"foo":   "bar",
+	handle(t, mux, "GetCapabilities", func(msg json.RawMessage) interface{} {
+		return &api.GetCapabilityResponse{
+			Scope:   "local",
+			Version: 2,
 		}
 	})
 
@@ -209,6 +215,9 @@ func TestGetExtraCapabilities(t *testing.T) {
 	} else if c.DataScope != datastore.LocalScope {
 		t.Fatalf("get capability '%s', expecting 'local'", c.DataScope)
 	}
+	if d.(*driver).version != 2 {
+		t.Fatalf("expected negotiated protocol version 2, got %d", d.(*driver).version)
+	}
 }
 
 func TestGetInvalidCapabilities(t *testing.T) {
@@ -217,10 +226,8 @@ func TestGetInvalidCapabilities(t *testing.T) {
 	mux := http.NewServeMux()
 	defer setupPlugin(t, plugin, mux)()
 
-	handle(t, mux, "GetCapabilities", func(msg map[string]interface{}) interface{} {
-		return map[string]interface{}{
-			"Scope": "fake",
-		}
+	handle(t, mux, "GetCapabilities", func(msg json.RawMessage) interface{} {
+		return &api.GetCapabilityResponse{Scope: "fake"}
 	})
 
 	p, err := plugins.Get(plugin, driverapi.NetworkPluginEndpointType)
@@ -263,68 +270,69 @@ func TestRemoteDriver(t *testing.T) {
 
 	var networkID string
 
-	handle(t, mux, "GetCapabilities", func(msg map[string]interface{}) interface{} {
-		return map[string]interface{}{
-			"Scope": "global",
-		}
+	handle(t, mux, "GetCapabilities", func(msg json.RawMessage) interface{} {
+		return &api.GetCapabilityResponse{Scope: "global"}
 	})
-	handle(t, mux, "CreateNetwork", func(msg map[string]interface{}) interface{} {
-		nid := msg["NetworkID"]
-		var ok bool
-		if networkID, ok = nid.(string); !ok {
+	handle(t, mux, "CreateNetwork", func(msg json.RawMessage) interface{} {
+		var req api.CreateNetworkRequest
+		decodeRequest(t, msg, &req)
+		if req.NetworkID == "" {
 			t.Fatal("RPC did not include network ID string")
 		}
-		return map[string]interface{}{}
+		networkID = req.NetworkID
+		return &api.Response{}
 	})
-	handle(t, mux, "DeleteNetwork", func(msg map[string]interface{}) interface{} {
-		if nid, ok := msg["NetworkID"]; !ok || nid != networkID {
+	handle(t, mux, "DeleteNetwork", func(msg json.RawMessage) interface{} {
+		var req api.DeleteNetworkRequest
+		decodeRequest(t, msg, &req)
+		if req.NetworkID != networkID {
 			t.Fatal("Network ID missing or does not match that created")
 		}
-		return map[string]interface{}{}
+		return &api.Response{}
 	})
-	handle(t, mux, "CreateEndpoint", func(msg map[string]interface{}) interface{} {
-		iface := map[string]interface{}{
-			"Address":     ep.address,
-			"AddressIPv6": ep.addressIPv6,
-			"MacAddress":  ep.macAddress,
-		}
-		return map[string]interface{}{
-			"Interface": iface,
+	handle(t, mux, "CreateEndpoint", func(msg json.RawMessage) interface{} {
+		return &api.CreateEndpointResponse{
+			Interface: api.EndpointInterface{
+				Address:     ep.address,
+				AddressIPv6: ep.addressIPv6,
+				MacAddress:  ep.macAddress,
+			},
 		}
 	})
-	handle(t, mux, "Join", func(msg map[string]interface{}) interface{} {
-		options := msg["Options"].(map[string]interface{})
-		foo, ok := options["foo"].(string)
+	handle(t, mux, "Join", func(msg json.RawMessage) interface{} {
+		var req api.JoinRequest
+		decodeRequest(t, msg, &req)
+		foo, ok := req.Options["foo"].(string)
 		if !ok || foo != "fooValue" {
-			t.Fatalf("Did not receive expected foo string in request options: %+v", msg)
+			t.Fatalf("Did not receive expected foo string in request options: %+v", req.Options)
 		}
-		return map[string]interface{}{
-			"Gateway":        ep.gateway,
-			"GatewayIPv6":    ep.gatewayIPv6,
-			"HostsPath":      ep.hostsPath,
-			"ResolvConfPath": ep.resolvConfPath,
-			"InterfaceName": map[string]interface{}{
-				"SrcName":   ep.src,
-				"DstPrefix": ep.dst,
+		return &api.JoinResponse{
+			Gateway:        ep.gateway,
+			GatewayIPv6:    ep.gatewayIPv6,
+			HostsPath:      ep.hostsPath,
+			ResolvConfPath: ep.resolvConfPath,
+			InterfaceName: api.InterfaceName{
+				SrcName:   ep.src,
+				DstPrefix: ep.dst,
 			},
-			"StaticRoutes": []map[string]interface{}{
-				map[string]interface{}{
-					"Destination": ep.destination,
-					"RouteType":   ep.routeType,
-					"NextHop":     ep.nextHop,
+			StaticRoutes: []api.StaticRoute{
+				{
+					Destination: ep.destination,
+					RouteType:   ep.routeType,
+					NextHop:     ep.nextHop,
 				},
 			},
 		}
 	})
-	handle(t, mux, "Leave", func(msg map[string]interface{}) interface{} {
-		return map[string]string{}
+	handle(t, mux, "Leave", func(msg json.RawMessage) interface{} {
+		return &api.Response{}
 	})
-	handle(t, mux, "DeleteEndpoint", func(msg map[string]interface{}) interface{} {
-		return map[string]interface{}{}
+	handle(t, mux, "DeleteEndpoint", func(msg json.RawMessage) interface{} {
+		return &api.Response{}
 	})
-	handle(t, mux, "EndpointOperInfo", func(msg map[string]interface{}) interface{} {
-		return map[string]interface{}{
-			"Value": map[string]string{
+	handle(t, mux, "EndpointOperInfo", func(msg json.RawMessage) interface{} {
+		return &api.EndpointInfoResponse{
+			Value: map[string]interface{}{
 				"Arbitrary": "key",
 				"Value":     "pairs?",
 			},
@@ -349,7 +357,7 @@ func TestRemoteDriver(t *testing.T) {
 	}
 
 	netID := "dummy-network"
-	err = d.CreateNetwork(netID, map[string]interface{}{})
+	err = d.CreateNetwork(netID, map[string]interface{}{}, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -398,9 +406,9 @@ func TestDriverError(t *testing.T) {
 	mux := http.NewServeMux()
 	defer setupPlugin(t, plugin, mux)()
 
-	handle(t, mux, "CreateEndpoint", func(msg map[string]interface{}) interface{} {
-		return map[string]interface{}{
-			"Err": "this should get raised as an error",
+	handle(t, mux, "CreateEndpoint", func(msg json.RawMessage) interface{} {
+		return &api.CreateEndpointResponse{
+			Response: api.Response{Err: "this should get raised as an error"},
 		}
 	})
 
@@ -426,15 +434,8 @@ func TestMissingValues(t *testing.T) {
 		t: t,
 	}
 
-	handle(t, mux, "CreateEndpoint", func(msg map[string]interface{}) interface{} {
-		iface := map[string]interface{}{
-			"Address":     ep.address,
-			"AddressIPv6": ep.addressIPv6,
-			"MacAddress":  ep.macAddress,
-		}
-		return map[string]interface{}{
-			"Interfaces": []interface{}{iface},
-		}
+	handle(t, mux, "CreateEndpoint", func(msg json.RawMessage) interface{} {
+		return &api.CreateEndpointResponse{}
 	})
 
 	p, err := plugins.Get(plugin, driverapi.NetworkPluginEndpointType)
@@ -467,19 +468,17 @@ func TestRollback(t *testing.T) {
 
 	rolledback := false
 
-	handle(t, mux, "CreateEndpoint", func(msg map[string]interface{}) interface{} {
-		iface := map[string]interface{}{
-			"Address":     "192.168.4.5/16",
-			"AddressIPv6": "",
-			"MacAddress":  "7a:12:34:56:78:90",
-		}
-		return map[string]interface{}{
-			"Interface": interface{}(iface),
+	handle(t, mux, "CreateEndpoint", func(msg json.RawMessage) interface{} {
+		return &api.CreateEndpointResponse{
+			Interface: api.EndpointInterface{
+				Address:    "192.168.4.5/16",
+				MacAddress: "7a:12:34:56:78:90",
+			},
 		}
 	})
-	handle(t, mux, "DeleteEndpoint", func(msg map[string]interface{}) interface{} {
+	handle(t, mux, "DeleteEndpoint", func(msg json.RawMessage) interface{} {
 		rolledback = true
-		return map[string]interface{}{}
+		return &api.Response{}
 	})
 
 	p, err := plugins.Get(plugin, driverapi.NetworkPluginEndpointType)
@@ -497,3 +496,283 @@ func TestRollback(t *testing.T) {
 		t.Fatalf("Expected to have had DeleteEndpoint called")
 	}
 }
+
+type testDriverCallback struct {
+	name string
+	driverapi.Driver
+	capability driverapi.Capability
+}
+
+func (t *testDriverCallback) RegisterDriver(name string, d driverapi.Driver, c driverapi.Capability) error {
+	t.name = name
+	t.Driver = d
+	t.capability = c
+	return nil
+}
+
+func TestDriverConfig(t *testing.T) {
+	var (
+		plugin     = "test-net-driver-config"
+		configured = false
+		options    = map[string]interface{}{"foo": "bar"}
+	)
+
+	mux := http.NewServeMux()
+	defer setupPlugin(t, plugin, mux)()
+
+	handle(t, mux, "GetCapabilities", func(msg json.RawMessage) interface{} {
+		return &api.GetCapabilityResponse{Scope: "local", Version: api.MinVersionConfig}
+	})
+	handle(t, mux, "Config", func(msg json.RawMessage) interface{} {
+		if configured {
+			t.Fatal("Config RPC fired more than once")
+		}
+		configured = true
+		var req api.ConfigRequest
+		decodeRequest(t, msg, &req)
+		if req.Options["foo"] != "bar" {
+			t.Fatalf("Did not receive expected options in Config RPC: %+v", req.Options)
+		}
+		return &api.Response{}
+	})
+	handle(t, mux, "CreateNetwork", func(msg json.RawMessage) interface{} {
+		if !configured {
+			t.Fatal("CreateNetwork fired before Config")
+		}
+		return &api.Response{}
+	})
+
+	dc := &testDriverCallback{}
+	drvConfig := map[string]interface{}{
+		config.OptionDriverConfig(plugin): options,
+	}
+	// Init's plugins.Handle callback negotiates capabilities, pushes
+	// Config and registers the driver, all synchronously with
+	// plugins.Get below — drive the whole flow through it rather than
+	// also pushing Config by hand, or Config fires twice.
+	if err := Init(dc, drvConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := plugins.Get(plugin, driverapi.NetworkPluginEndpointType); err != nil {
+		t.Fatal(err)
+	}
+	if !configured {
+		t.Fatal("Expected Config RPC to have fired")
+	}
+	if dc.Driver == nil {
+		t.Fatal("Expected driver to have been registered")
+	}
+	if err := dc.Driver.CreateNetwork("dummy-network", map[string]interface{}{}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestDriverConfigSkippedForLegacyPlugin makes sure a plugin that predates
+// the Config RPC (it doesn't report a protocol version, or reports one
+// below api.MinVersionConfig) still registers: Config must not be pushed
+// to it, or registration would break on every pre-existing remote plugin
+// that doesn't implement NetworkDriver.Config.
+func TestDriverConfigSkippedForLegacyPlugin(t *testing.T) {
+	var plugin = "test-net-driver-config-legacy"
+
+	mux := http.NewServeMux()
+	defer setupPlugin(t, plugin, mux)()
+
+	handle(t, mux, "GetCapabilities", func(msg json.RawMessage) interface{} {
+		return &api.GetCapabilityResponse{Scope: "local"}
+	})
+	handle(t, mux, "Config", func(msg json.RawMessage) interface{} {
+		t.Fatal("Config RPC should not be sent to a legacy plugin")
+		return &api.Response{}
+	})
+
+	dc := &testDriverCallback{}
+	if err := Init(dc, map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := plugins.Get(plugin, driverapi.NetworkPluginEndpointType); err != nil {
+		t.Fatal(err)
+	}
+	if dc.Driver == nil {
+		t.Fatal("Expected driver to have been registered despite not implementing Config")
+	}
+}
+
+func TestCreateNetworkIPAMData(t *testing.T) {
+	var plugin = "test-net-driver-ipam-data"
+
+	mux := http.NewServeMux()
+	defer setupPlugin(t, plugin, mux)()
+
+	handle(t, mux, "CreateNetwork", func(msg json.RawMessage) interface{} {
+		var req api.CreateNetworkRequest
+		decodeRequest(t, msg, &req)
+		if len(req.IPv4Data) != 1 {
+			t.Fatalf("expected one IPv4Data entry, got %d", len(req.IPv4Data))
+		}
+		d := req.IPv4Data[0]
+		if d.Pool != "192.168.0.0/16" {
+			t.Fatalf("wrong pool marshalled: %s", d.Pool)
+		}
+		if d.Gateway != "192.168.0.1/16" {
+			t.Fatalf("wrong gateway marshalled: %s", d.Gateway)
+		}
+		if d.AuxAddresses["host"] != "192.168.0.2/16" {
+			t.Fatalf("wrong aux address marshalled: %s", d.AuxAddresses["host"])
+		}
+		if len(req.IPv6Data) != 0 {
+			t.Fatalf("expected no IPv6Data entries, got %d", len(req.IPv6Data))
+		}
+		return &api.Response{}
+	})
+
+	p, err := plugins.Get(plugin, driverapi.NetworkPluginEndpointType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newDriver(plugin, p.Client)
+
+	_, pool, _ := net.ParseCIDR("192.168.0.0/16")
+	gwIP, gw, _ := net.ParseCIDR("192.168.0.1/16")
+	gw.IP = gwIP
+	auxIP, aux, _ := net.ParseCIDR("192.168.0.2/16")
+	aux.IP = auxIP
+
+	ipV4Data := []driverapi.IPAMData{
+		{
+			AddressSpace: "global",
+			Pool:         pool,
+			Gateway:      gw,
+			AuxAddresses: map[string]*net.IPNet{"host": aux},
+		},
+	}
+
+	if err := d.CreateNetwork("dummy-network", map[string]interface{}{}, ipV4Data, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiscoverNewDelete(t *testing.T) {
+	var plugin = "test-net-driver-discover"
+
+	mux := http.NewServeMux()
+	defer setupPlugin(t, plugin, mux)()
+
+	var newAddr, delAddr string
+
+	handle(t, mux, "GetCapabilities", func(msg json.RawMessage) interface{} {
+		return &api.GetCapabilityResponse{Scope: "global"}
+	})
+	handle(t, mux, "DiscoverNew", func(msg json.RawMessage) interface{} {
+		var notif api.DiscoveryNotification
+		decodeRequest(t, msg, &notif)
+		data, ok := notif.DiscoveryData.(map[string]interface{})
+		if !ok {
+			t.Fatal("DiscoverNew did not carry NodeDiscoveryData")
+		}
+		newAddr, _ = data["Address"].(string)
+		return &api.Response{}
+	})
+	handle(t, mux, "DiscoverDelete", func(msg json.RawMessage) interface{} {
+		var notif api.DiscoveryNotification
+		decodeRequest(t, msg, &notif)
+		data, ok := notif.DiscoveryData.(map[string]interface{})
+		if !ok {
+			t.Fatal("DiscoverDelete did not carry NodeDiscoveryData")
+		}
+		delAddr, _ = data["Address"].(string)
+		return &api.Response{}
+	})
+
+	p, err := plugins.Get(plugin, driverapi.NetworkPluginEndpointType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newDriver(plugin, p.Client)
+
+	c, err := d.(*driver).getCapabilities()
+	if err != nil {
+		t.Fatal(err)
+	} else if c.DataScope != datastore.GlobalScope {
+		t.Fatalf("get capability '%s', expecting 'global'", c.DataScope)
+	}
+
+	nodeData := driverapi.NodeDiscoveryData{Address: "192.168.1.1", Self: true}
+	if err := d.DiscoverNew(driverapi.NodeDiscovery, nodeData); err != nil {
+		t.Fatal(err)
+	}
+	if newAddr != "192.168.1.1" {
+		t.Fatalf("expected DiscoverNew to carry node address, got %q", newAddr)
+	}
+
+	if err := d.DiscoverDelete(driverapi.NodeDiscovery, nodeData); err != nil {
+		t.Fatal(err)
+	}
+	if delAddr != "192.168.1.1" {
+		t.Fatalf("expected DiscoverDelete to carry node address, got %q", delAddr)
+	}
+}
+
+func TestProgramExternalConnectivity(t *testing.T) {
+	var plugin = "test-net-driver-external-connectivity"
+
+	mux := http.NewServeMux()
+	defer setupPlugin(t, plugin, mux)()
+
+	handle(t, mux, "ProgramExternalConnectivity", func(msg json.RawMessage) interface{} {
+		var req api.ProgramExternalConnectivityRequest
+		decodeRequest(t, msg, &req)
+		if req.NetworkID != "dummy" || req.EndpointID != "dummy-ep" {
+			t.Fatalf("unexpected request: %+v", req)
+		}
+		return &api.Response{}
+	})
+	handle(t, mux, "RevokeExternalConnectivity", func(msg json.RawMessage) interface{} {
+		return &api.Response{}
+	})
+
+	p, err := plugins.Get(plugin, driverapi.NetworkPluginEndpointType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newDriver(plugin, p.Client)
+
+	if err := d.ProgramExternalConnectivity("dummy", "dummy-ep", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RevokeExternalConnectivity("dummy", "dummy-ep"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProgramExternalConnectivityRollback(t *testing.T) {
+	var plugin = "test-net-driver-external-connectivity-rollback"
+
+	mux := http.NewServeMux()
+	defer setupPlugin(t, plugin, mux)()
+
+	rolledback := false
+
+	handle(t, mux, "ProgramExternalConnectivity", func(msg json.RawMessage) interface{} {
+		return &api.Response{Err: "failed to program external connectivity"}
+	})
+	handle(t, mux, "Leave", func(msg json.RawMessage) interface{} {
+		rolledback = true
+		return &api.Response{}
+	})
+
+	p, err := plugins.Get(plugin, driverapi.NetworkPluginEndpointType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newDriver(plugin, p.Client)
+
+	if err := d.ProgramExternalConnectivity("dummy", "dummy-ep", map[string]interface{}{}); err == nil {
+		t.Fatal("Expected error from driver")
+	}
+	if !rolledback {
+		t.Fatal("Expected to have had Leave called")
+	}
+}
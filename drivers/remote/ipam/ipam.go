@@ -0,0 +1,228 @@
+package ipam
+
+import (
+	"fmt"
+	"net"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/pkg/plugins"
+	"github.com/docker/libnetwork/ipamapi"
+)
+
+type allocator struct {
+	endpoint *plugins.Client
+	name     string
+}
+
+type maybeError interface {
+	Error() string
+}
+
+type response struct {
+	Err string
+}
+
+func (r *response) Error() string {
+	return r.Err
+}
+
+func newAllocator(name string, client *plugins.Client) ipamapi.Ipam {
+	return &allocator{name: name, endpoint: client}
+}
+
+// Init registers a remote ipam when its plugin is activated.
+func Init(cb ipamapi.Callback, config map[string]interface{}) error {
+	plugins.Handle(ipamapi.PluginEndpointType, func(name string, client *plugins.Client) {
+		a := newAllocator(name, client)
+		if cps, ok := a.(*allocator); ok {
+			c, err := cps.getCapabilities()
+			if err != nil {
+				log.Errorf("error getting capability for %s due to %v", name, err)
+				return
+			}
+			if err := cb.RegisterIpamDriverWithCapabilities(name, a, c); err != nil {
+				log.Errorf("error registering ipam driver for %s due to %v", name, err)
+			}
+			return
+		}
+	})
+	return nil
+}
+
+func (a *allocator) call(methodName string, arg interface{}, retVal maybeError) error {
+	method := ipamapi.PluginEndpointType + "." + methodName
+	err := a.endpoint.Call(method, arg, retVal)
+	if err != nil {
+		return err
+	}
+	if e := retVal.Error(); e != "" {
+		return fmt.Errorf("remote: %s", e)
+	}
+	return nil
+}
+
+type capabilitiesResponse struct {
+	response
+	RequiresMACAddress    bool
+	RequiresRequestReplay bool
+}
+
+func (a *allocator) getCapabilities() (*ipamapi.Capability, error) {
+	var res capabilitiesResponse
+	if err := a.call("GetCapabilities", nil, &res); err != nil {
+		return nil, err
+	}
+	return &ipamapi.Capability{
+		RequiresMACAddress:    res.RequiresMACAddress,
+		RequiresRequestReplay: res.RequiresRequestReplay,
+	}, nil
+}
+
+type addressSpacesResponse struct {
+	response
+	LocalDefaultAddressSpace  string
+	GlobalDefaultAddressSpace string
+}
+
+func (a *allocator) GetDefaultAddressSpaces() (string, string, error) {
+	var res addressSpacesResponse
+	if err := a.call("GetDefaultAddressSpaces", nil, &res); err != nil {
+		return "", "", err
+	}
+	return res.LocalDefaultAddressSpace, res.GlobalDefaultAddressSpace, nil
+}
+
+type requestPoolRequest struct {
+	AddressSpace string
+	Pool         string
+	SubPool      string
+	Options      map[string]string
+	V6           bool
+}
+
+type requestPoolResponse struct {
+	response
+	PoolID string
+	Pool   string
+	Data   map[string]interface{}
+}
+
+func (a *allocator) RequestPool(addressSpace, pool, subPool string, options map[string]string, v6 bool) (string, *net.IPNet, map[string]string, error) {
+	req := &requestPoolRequest{
+		AddressSpace: addressSpace,
+		Pool:         pool,
+		SubPool:      subPool,
+		Options:      options,
+		V6:           v6,
+	}
+	var res requestPoolResponse
+	if err := a.call("RequestPool", req, &res); err != nil {
+		return "", nil, nil, err
+	}
+
+	retPool, err := parseCIDR(res.Pool)
+	if err != nil {
+		// The plugin already committed the pool on its side; a pool we
+		// can't parse is unusable to us, so release it rather than leak it.
+		if e := a.call("ReleasePool", &releasePoolRequest{PoolID: res.PoolID}, &response{}); e != nil {
+			log.Warnf("allocator failed to roll back pool request after error (%v): %v", err, e)
+		}
+		return "", nil, nil, err
+	}
+
+	return res.PoolID, retPool, stringifyData(res.Data), nil
+}
+
+type releasePoolRequest struct {
+	PoolID string
+}
+
+func (a *allocator) ReleasePool(poolID string) error {
+	req := &releasePoolRequest{PoolID: poolID}
+	return a.call("ReleasePool", req, &response{})
+}
+
+type requestAddressRequest struct {
+	PoolID  string
+	Address string
+	Options map[string]string
+}
+
+type requestAddressResponse struct {
+	response
+	Address string
+	Data    map[string]interface{}
+}
+
+func (a *allocator) RequestAddress(poolID string, address net.IP, options map[string]string) (*net.IPNet, map[string]string, error) {
+	var addr string
+	if address != nil {
+		addr = address.String()
+	}
+	req := &requestAddressRequest{
+		PoolID:  poolID,
+		Address: addr,
+		Options: options,
+	}
+	var res requestAddressResponse
+	if err := a.call("RequestAddress", req, &res); err != nil {
+		return nil, nil, err
+	}
+
+	retAddress, err := parseCIDR(res.Address)
+	if err != nil {
+		// The plugin already committed the address on its side; an
+		// address we can't parse is unusable to us, so release it
+		// rather than leak it.
+		if e := a.call("ReleaseAddress", &releaseAddressRequest{PoolID: poolID, Address: res.Address}, &response{}); e != nil {
+			log.Warnf("allocator failed to roll back address request after error (%v): %v", err, e)
+		}
+		return nil, nil, err
+	}
+
+	return retAddress, stringifyData(res.Data), nil
+}
+
+// parseCIDR turns a "<ip>/<prefix>" string as returned by a plugin into a
+// net.IPNet with the IP itself (not the network address) preserved.
+func parseCIDR(s string) (*net.IPNet, error) {
+	if s == "" {
+		return nil, nil
+	}
+	ip, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, err
+	}
+	ipNet.IP = ip
+	return ipNet, nil
+}
+
+// stringifyData converts the plugin-supplied, loosely-typed Data map into
+// the map[string]string that libnetwork's IPAM API passes on to callers.
+func stringifyData(data map[string]interface{}) map[string]string {
+	if len(data) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(data))
+	for k, v := range data {
+		out[k] = fmt.Sprintf("%v", v)
+	}
+	return out
+}
+
+type releaseAddressRequest struct {
+	PoolID  string
+	Address string
+}
+
+func (a *allocator) ReleaseAddress(poolID string, address net.IP) error {
+	var addr string
+	if address != nil {
+		addr = address.String()
+	}
+	req := &releaseAddressRequest{
+		PoolID:  poolID,
+		Address: addr,
+	}
+	return a.call("ReleaseAddress", req, &response{})
+}
@@ -0,0 +1,252 @@
+package ipam
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/docker/docker/pkg/plugins"
+	"github.com/docker/libnetwork/ipamapi"
+	_ "github.com/docker/libnetwork/testutils"
+)
+
+func decodeToMap(r *http.Request) (res map[string]interface{}, err error) {
+	err = json.NewDecoder(r.Body).Decode(&res)
+	return
+}
+
+func handle(t *testing.T, mux *http.ServeMux, method string, h func(map[string]interface{}) interface{}) {
+	mux.HandleFunc(fmt.Sprintf("/%s.%s", ipamapi.PluginEndpointType, method), func(w http.ResponseWriter, r *http.Request) {
+		ask, err := decodeToMap(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		answer := h(ask)
+		if err := json.NewEncoder(w).Encode(&answer); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func setupPlugin(t *testing.T, name string, mux *http.ServeMux) func() {
+	if err := os.MkdirAll("/usr/share/docker/plugins", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("unix", fmt.Sprintf("/usr/share/docker/plugins/%s.sock", name))
+	if err != nil {
+		t.Fatal("Could not listen to the plugin socket")
+	}
+
+	mux.HandleFunc("/Plugin.Activate", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"Implements": ["%s"]}`, ipamapi.PluginEndpointType)
+	})
+
+	go http.Serve(listener, mux)
+
+	return func() {
+		listener.Close()
+		if err := os.RemoveAll("/usr/share/docker/plugins"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGetCapabilities(t *testing.T) {
+	var plugin = "test-ipam-driver-cap"
+
+	mux := http.NewServeMux()
+	defer setupPlugin(t, plugin, mux)()
+
+	handle(t, mux, "GetCapabilities", func(msg map[string]interface{}) interface{} {
+		return map[string]interface{}{
+			"RequiresMACAddress":    true,
+			"RequiresRequestReplay": true,
+		}
+	})
+
+	p, err := plugins.Get(plugin, ipamapi.PluginEndpointType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := newAllocator(plugin, p.Client)
+	c, err := a.(*allocator).getCapabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.RequiresMACAddress || !c.RequiresRequestReplay {
+		t.Fatalf("Unexpected capability: %+v", c)
+	}
+}
+
+func TestRemoteIpamDriver(t *testing.T) {
+	var plugin = "test-ipam-driver"
+
+	mux := http.NewServeMux()
+	defer setupPlugin(t, plugin, mux)()
+
+	var poolID string
+
+	handle(t, mux, "GetDefaultAddressSpaces", func(msg map[string]interface{}) interface{} {
+		return map[string]interface{}{
+			"LocalDefaultAddressSpace":  "local",
+			"GlobalDefaultAddressSpace": "global",
+		}
+	})
+	handle(t, mux, "RequestPool", func(msg map[string]interface{}) interface{} {
+		poolID = "pool-id"
+		return map[string]interface{}{
+			"PoolID": poolID,
+			"Pool":   "192.168.0.0/16",
+			"Data":   map[string]interface{}{"some-key": "some-value"},
+		}
+	})
+	handle(t, mux, "RequestAddress", func(msg map[string]interface{}) interface{} {
+		if msg["PoolID"] != poolID {
+			t.Fatalf("Wrong PoolID; expected %q, got %v", poolID, msg["PoolID"])
+		}
+		return map[string]interface{}{
+			"Address": "192.168.0.1/16",
+			"Data":    map[string]interface{}{"other-key": "other-value"},
+		}
+	})
+	handle(t, mux, "ReleaseAddress", func(msg map[string]interface{}) interface{} {
+		return map[string]interface{}{}
+	})
+	handle(t, mux, "ReleasePool", func(msg map[string]interface{}) interface{} {
+		if msg["PoolID"] != poolID {
+			t.Fatalf("Wrong PoolID; expected %q, got %v", poolID, msg["PoolID"])
+		}
+		return map[string]interface{}{}
+	})
+
+	p, err := plugins.Get(plugin, ipamapi.PluginEndpointType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := newAllocator(plugin, p.Client)
+
+	local, global, err := a.GetDefaultAddressSpaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if local != "local" || global != "global" {
+		t.Fatalf("Unexpected default address spaces: %s, %s", local, global)
+	}
+
+	gotPoolID, pool, poolData, err := a.RequestPool("global", "192.168.0.0/16", "", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotPoolID != poolID {
+		t.Fatalf("Wrong pool id returned: %s", gotPoolID)
+	}
+	if pool.String() != "192.168.0.0/16" {
+		t.Fatalf("Wrong pool returned: %s", pool.String())
+	}
+	if poolData["some-key"] != "some-value" {
+		t.Fatalf("Plugin-supplied pool data was not passed through: %+v", poolData)
+	}
+
+	addr, addrData, err := a.RequestAddress(gotPoolID, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.IP.String() != "192.168.0.1" {
+		t.Fatalf("Wrong address returned: %s", addr.IP.String())
+	}
+	if addrData["other-key"] != "other-value" {
+		t.Fatalf("Plugin-supplied address data was not passed through: %+v", addrData)
+	}
+
+	if err := a.ReleaseAddress(gotPoolID, addr.IP); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.ReleasePool(gotPoolID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestRequestAddressRollback exercises the rollback path that mirrors the
+// network driver's endpoint-creation rollback: RequestAddress succeeds at
+// the RPC level, but the address it comes back with can't be parsed, so
+// the allocator must release it on the plugin's side rather than leak it.
+func TestRequestAddressRollback(t *testing.T) {
+	var plugin = "test-ipam-driver-rollback"
+
+	mux := http.NewServeMux()
+	defer setupPlugin(t, plugin, mux)()
+
+	released := false
+
+	handle(t, mux, "RequestAddress", func(msg map[string]interface{}) interface{} {
+		return map[string]interface{}{
+			"Address": "not-a-valid-cidr",
+		}
+	})
+	handle(t, mux, "ReleaseAddress", func(msg map[string]interface{}) interface{} {
+		if msg["PoolID"] != "pool-id" || msg["Address"] != "not-a-valid-cidr" {
+			t.Fatalf("Unexpected ReleaseAddress request: %+v", msg)
+		}
+		released = true
+		return map[string]interface{}{}
+	})
+
+	p, err := plugins.Get(plugin, ipamapi.PluginEndpointType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := newAllocator(plugin, p.Client)
+
+	if _, _, err := a.RequestAddress("pool-id", nil, nil); err == nil {
+		t.Fatal("Expected error from allocator due to unparseable address")
+	}
+	if !released {
+		t.Fatal("Expected ReleaseAddress to have been called to roll back the unusable address")
+	}
+}
+
+// TestRequestPoolRollback is the RequestPool counterpart of
+// TestRequestAddressRollback: an unparseable pool must be released.
+func TestRequestPoolRollback(t *testing.T) {
+	var plugin = "test-ipam-driver-pool-rollback"
+
+	mux := http.NewServeMux()
+	defer setupPlugin(t, plugin, mux)()
+
+	released := false
+
+	handle(t, mux, "RequestPool", func(msg map[string]interface{}) interface{} {
+		return map[string]interface{}{
+			"PoolID": "pool-id",
+			"Pool":   "not-a-valid-cidr",
+		}
+	})
+	handle(t, mux, "ReleasePool", func(msg map[string]interface{}) interface{} {
+		if msg["PoolID"] != "pool-id" {
+			t.Fatalf("Unexpected ReleasePool request: %+v", msg)
+		}
+		released = true
+		return map[string]interface{}{}
+	})
+
+	p, err := plugins.Get(plugin, ipamapi.PluginEndpointType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := newAllocator(plugin, p.Client)
+
+	if _, _, _, err := a.RequestPool("global", "", "", nil, false); err == nil {
+		t.Fatal("Expected error from allocator due to unparseable pool")
+	}
+	if !released {
+		t.Fatal("Expected ReleasePool to have been called to roll back the unusable pool")
+	}
+}